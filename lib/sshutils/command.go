@@ -0,0 +1,70 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sshutils
+
+import (
+	"io"
+	"strings"
+
+	"github.com/gravitational/trace"
+)
+
+// CommandPolicy validates a tokenized command line before it is sent to
+// the remote side, letting callers enforce allow-lists (e.g. only
+// permit "scp" or "rsync --server") and reject shell metacharacter
+// injection attempts. It should return a descriptive error to reject
+// argv, or nil to allow it.
+type CommandPolicy func(argv []string) error
+
+// SetCommandPolicy installs p as the policy consulted by CommandRW,
+// PipeCommand and SafeCommand before a command is executed on the
+// remote side. Pass nil to allow any command through.
+func (u *Upstream) SetCommandPolicy(p CommandPolicy) {
+	u.Lock()
+	defer u.Unlock()
+	u.policy = p
+}
+
+func (u *Upstream) checkPolicy(argv []string) error {
+	u.Lock()
+	policy := u.policy
+	u.Unlock()
+	if policy == nil {
+		return nil
+	}
+	return trace.Wrap(policy(argv))
+}
+
+// SafeCommand tokenizes argv using the same quoting rules as
+// ParseCommand, validates it against the configured CommandPolicy, and
+// starts it on the remote session. Unlike passing a raw string to
+// CommandRW, each element of argv is quoted so the remote shell sees
+// exactly the tokens passed in, rather than re-parsing a command line
+// that may contain metacharacters from an untrusted source.
+func (u *Upstream) SafeCommand(argv ...string) (io.ReadWriter, error) {
+	return u.CommandRW(quoteCommand(argv))
+}
+
+// quoteCommand joins argv into a single command string, single-quoting
+// each argument so it round-trips through the remote shell unchanged.
+func quoteCommand(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		quoted[i] = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sshutils
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/gravitational/trace"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ExitResult describes how a remote command or shell session completed,
+// capturing both the "exit-status" and "exit-signal" results the SSH
+// protocol can report (RFC 4254 section 6.10), instead of collapsing
+// them into a single status code.
+type ExitResult struct {
+	// Code is the exit status code of the remote process. It is only
+	// meaningful when Signal is empty.
+	Code int
+	// Signal is the name of the signal that terminated the remote
+	// process (e.g. "TERM", without the "SIG" prefix). It is empty if
+	// the process exited normally.
+	Signal string
+	// CoreDumped is true if the remote process produced a core dump
+	// before terminating due to Signal.
+	CoreDumped bool
+	// ErrMsg is a human readable message describing the failure, as
+	// reported by the remote side.
+	ErrMsg string
+}
+
+// exitResult translates the error returned by (*ssh.Session).Wait into
+// an ExitResult, handling both the normal exit-status case and the
+// exit-signal case reported via *ssh.ExitError.
+func exitResult(err error) (*ExitResult, error) {
+	if err == nil {
+		return &ExitResult{}, nil
+	}
+	if exitErr, ok := err.(*ssh.ExitError); ok {
+		return &ExitResult{
+			Code:       exitErr.ExitStatus(),
+			Signal:     exitErr.Signal(),
+			CoreDumped: exitErr.CoreDumped(),
+			ErrMsg:     exitErr.Msg(),
+		}, nil
+	}
+	return nil, trace.Wrap(err)
+}
+
+// forwardSignals relays signals received on sigC to the upstream session
+// until sigC is closed or done is closed, whichever happens first. It is
+// a no-op if sigC is nil, allowing callers that don't need signal
+// forwarding to keep using PipeCommand/PipeShell exactly as before. done
+// must be closed by the caller once the session it belongs to ends, so
+// that a caller forgetting to close sigC doesn't leak this goroutine for
+// the life of the process.
+func (u *Upstream) forwardSignals(sigC <-chan ssh.Signal, done <-chan struct{}) {
+	if sigC == nil {
+		return
+	}
+	go func() {
+		for {
+			select {
+			case sig, ok := <-sigC:
+				if !ok {
+					return
+				}
+				if err := u.session.Signal(sig); err != nil {
+					logrus.Error(err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+}
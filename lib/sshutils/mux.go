@@ -0,0 +1,124 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sshutils
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/gravitational/trace"
+	"github.com/hashicorp/yamux"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// muxChannelType is the SSH channel type used to carry a yamux session
+// multiplexing many virtual streams over a single underlying SSH
+// channel, avoiding a new TCP+SSH handshake per interactive session.
+const muxChannelType = "teleport-mux@gravitational.com"
+
+// OpenMuxedSession opens a single direct SSH channel on this upstream's
+// connection and layers a yamux client session over it, so many
+// concurrent shells, PipeCommands and file transfers can share one
+// handshake instead of dialing a new TCP+SSH connection each time.
+func (u *Upstream) OpenMuxedSession() (*MuxedUpstream, error) {
+	channel, requests, err := u.client.OpenChannel(muxChannelType, nil)
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to open mux channel")
+	}
+	go ssh.DiscardRequests(requests)
+
+	session, err := yamux.Client(channel, nil)
+	if err != nil {
+		channel.Close()
+		return nil, trace.Wrap(err, "failed to start yamux client")
+	}
+	mux := &MuxedUpstream{addr: u.addr, client: u.client, mux: session}
+
+	u.Lock()
+	u.mux = mux
+	u.Unlock()
+
+	return mux, nil
+}
+
+// NewMuxedUpstream wraps clt, mirroring NewUpstream(clt), and immediately
+// opens a muxed session on top of it. The remote side is expected to
+// accept the muxChannelType channel and run a yamux peer over it.
+func NewMuxedUpstream(clt *ssh.Client) (*MuxedUpstream, error) {
+	u := &Upstream{addr: clt.Conn.RemoteAddr().String(), client: clt}
+	return u.OpenMuxedSession()
+}
+
+// MuxedUpstream is an Upstream-like handle backed by a single SSH
+// channel multiplexed with yamux, used to hand out many lightweight
+// virtual streams without paying for a new TCP+SSH handshake each time.
+type MuxedUpstream struct {
+	addr   string
+	client *ssh.Client
+	mux    *yamux.Session
+}
+
+// String returns debug-friendly information about this muxed upstream
+func (m *MuxedUpstream) String() string {
+	return fmt.Sprintf("muxedUpstream(addr=%v)", m.addr)
+}
+
+// OpenStream opens a new virtual stream to the peer. The returned
+// io.ReadWriteCloser is wire-compatible with combo, so PipeCommand and
+// PipeShell work unchanged on top of it.
+func (m *MuxedUpstream) OpenStream() (io.ReadWriteCloser, error) {
+	stream, err := m.mux.Open()
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to open muxed stream")
+	}
+	return stream, nil
+}
+
+// acceptStream blocks until the peer opens a new virtual stream on this
+// muxed session and returns it.
+func (m *MuxedUpstream) acceptStream() (io.ReadWriteCloser, error) {
+	stream, err := m.mux.Accept()
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to accept muxed stream")
+	}
+	return stream, nil
+}
+
+// Close shuts down the yamux session and the underlying SSH client.
+func (m *MuxedUpstream) Close() error {
+	return CloseAll(m.mux, m.client)
+}
+
+// AcceptStream blocks until the peer opens a new virtual stream over
+// this upstream's muxed session and returns it, opening one via
+// OpenMuxedSession on first use if one isn't already established. It is
+// the server-side counterpart of MuxedUpstream.OpenStream.
+func (u *Upstream) AcceptStream() (io.ReadWriteCloser, error) {
+	u.Lock()
+	mux := u.mux
+	u.Unlock()
+
+	if mux == nil {
+		var err error
+		mux, err = u.OpenMuxedSession()
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+	}
+	return mux.acceptStream()
+}
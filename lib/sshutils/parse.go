@@ -0,0 +1,163 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sshutils
+
+import "github.com/gravitational/trace"
+
+// parseState is a tokenizer state for ParseCommand
+type parseState int
+
+const (
+	stateDefault parseState = iota
+	stateInSingle
+	stateInDouble
+	stateInDollarSingle
+	stateInBackslash
+)
+
+// ParseCommand tokenizes raw, the command line carried by an SSH "exec"
+// request, using POSIX shell quoting rules:
+//
+//   - 'single quotes' preserve everything inside literally
+//   - "double quotes" allow backslash to escape \, $, " and newline
+//   - $'dollar single' is a C-string: \n, \t and \r are expanded
+//   - a bare backslash escapes the following character
+//   - a '#' starting a new word begins a comment that runs to end of line
+//
+// It returns a precise error if raw ends while still inside a quote or
+// escape sequence.
+func ParseCommand(raw string) ([]string, error) {
+	var argv []string
+	var word []rune
+	haveWord := false
+	state := stateDefault
+	prevState := stateDefault
+
+	flush := func() {
+		if haveWord {
+			argv = append(argv, string(word))
+		}
+		word = nil
+		haveWord = false
+	}
+
+	runes := []rune(raw)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch state {
+		case stateDefault:
+			switch {
+			case r == '\'':
+				state = stateInSingle
+				haveWord = true
+			case r == '"':
+				state = stateInDouble
+				haveWord = true
+			case r == '$' && i+1 < len(runes) && runes[i+1] == '\'':
+				state = stateInDollarSingle
+				haveWord = true
+				i++
+			case r == '\\':
+				prevState = stateDefault
+				state = stateInBackslash
+				haveWord = true
+			case r == '#' && !haveWord:
+				for i < len(runes) && runes[i] != '\n' {
+					i++
+				}
+			case r == ' ' || r == '\t' || r == '\n':
+				flush()
+			default:
+				word = append(word, r)
+				haveWord = true
+			}
+		case stateInSingle:
+			if r == '\'' {
+				state = stateDefault
+			} else {
+				word = append(word, r)
+			}
+		case stateInDouble:
+			switch r {
+			case '"':
+				state = stateDefault
+			case '\\':
+				prevState = stateInDouble
+				state = stateInBackslash
+			default:
+				word = append(word, r)
+			}
+		case stateInDollarSingle:
+			switch r {
+			case '\'':
+				state = stateDefault
+			case '\\':
+				prevState = stateInDollarSingle
+				state = stateInBackslash
+			default:
+				word = append(word, r)
+			}
+		case stateInBackslash:
+			word = append(word, unescape(r, prevState)...)
+			state = prevState
+		}
+	}
+
+	switch state {
+	case stateInSingle, stateInDouble, stateInDollarSingle:
+		return nil, trace.Errorf("unterminated quote in command: %q", raw)
+	case stateInBackslash:
+		return nil, trace.Errorf("unterminated escape sequence in command: %q", raw)
+	}
+
+	flush()
+	return argv, nil
+}
+
+// unescape maps the character following a backslash to the rune(s) it
+// should expand to. Inside a $'...' C-string the common escapes are
+// expanded and the backslash always consumed. Inside "..." double
+// quotes, POSIX only lets a backslash escape \, $, " and newline; for
+// any other character the backslash is not special and must be kept, or
+// the argv the CommandPolicy validates would diverge from what the
+// command-line-consuming shell on the other end actually sees. A bare
+// backslash outside any quoting always consumes itself and keeps the
+// following character unchanged.
+func unescape(r rune, ctx parseState) []rune {
+	switch ctx {
+	case stateInDollarSingle:
+		switch r {
+		case 'n':
+			return []rune{'\n'}
+		case 't':
+			return []rune{'\t'}
+		case 'r':
+			return []rune{'\r'}
+		default:
+			return []rune{r}
+		}
+	case stateInDouble:
+		switch r {
+		case '\\', '$', '"', '\n':
+			return []rune{r}
+		default:
+			return []rune{'\\', r}
+		}
+	default:
+		return []rune{r}
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sshutils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	cases := []struct {
+		desc    string
+		raw     string
+		argv    []string
+		wantErr bool
+	}{
+		{
+			desc: "plain words",
+			raw:  "scp -t foo",
+			argv: []string{"scp", "-t", "foo"},
+		},
+		{
+			desc: "semicolon is an ordinary word character, not a separator",
+			raw:  "scp -t foo; rm -rf /",
+			argv: []string{"scp", "-t", "foo;", "rm", "-rf", "/"},
+		},
+		{
+			desc: "pipe is an ordinary word character, not a separator",
+			raw:  "scp -t foo | rm -rf /",
+			argv: []string{"scp", "-t", "foo", "|", "rm", "-rf", "/"},
+		},
+		{
+			desc: "single quotes preserve everything literally",
+			raw:  `rsync --server '--log-format=%i; rm -rf /'`,
+			argv: []string{"rsync", "--server", "--log-format=%i; rm -rf /"},
+		},
+		{
+			desc: `double quotes only let backslash escape \, $, " and newline`,
+			raw:  `echo "\d\$\"\\"`,
+			argv: []string{"echo", `\d$"\`},
+		},
+		{
+			desc: "dollar-single expands common C-string escapes",
+			raw:  `echo $'a\tb\nc'`,
+			argv: []string{"echo", "a\tb\nc"},
+		},
+		{
+			desc: "bare backslash escapes the next character and is dropped",
+			raw:  `echo foo\ bar`,
+			argv: []string{"echo", "foo bar"},
+		},
+		{
+			desc: "comment starting a word runs to end of line",
+			raw:  "scp -t foo # rm -rf /",
+			argv: []string{"scp", "-t", "foo"},
+		},
+		{
+			desc:    "unterminated single quote is an error",
+			raw:     "scp 'foo",
+			wantErr: true,
+		},
+		{
+			desc:    "unterminated double quote is an error",
+			raw:     `scp "foo`,
+			wantErr: true,
+		},
+		{
+			desc:    "trailing backslash is an error",
+			raw:     `scp foo\`,
+			wantErr: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			argv, err := ParseCommand(tc.raw)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("ParseCommand(%q): expected error, got argv %#v", tc.raw, argv)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseCommand(%q): unexpected error: %v", tc.raw, err)
+			}
+			if !reflect.DeepEqual(argv, tc.argv) {
+				t.Fatalf("ParseCommand(%q) = %#v, want %#v", tc.raw, argv, tc.argv)
+			}
+		})
+	}
+}
+
+// TestParseCommandPolicyOnWhatRuns guards against the tokenized argv a
+// CommandPolicy validates drifting from what the remote shell actually
+// runs: quoteCommand must requote argv so that metacharacters the
+// tokenizer didn't split on (';', '|', etc) stay inert when the result
+// is parsed by a real shell.
+func TestParseCommandPolicyOnWhatRuns(t *testing.T) {
+	raw := "scp -t foo; rm -rf /"
+	argv, err := ParseCommand(raw)
+	if err != nil {
+		t.Fatalf("ParseCommand(%q): unexpected error: %v", raw, err)
+	}
+	if argv[0] != "scp" {
+		t.Fatalf("expected argv[0] == %q, got %q", "scp", argv[0])
+	}
+
+	requoted := quoteCommand(argv)
+	reparsed, err := ParseCommand(requoted)
+	if err != nil {
+		t.Fatalf("ParseCommand(%q): unexpected error: %v", requoted, err)
+	}
+	if !reflect.DeepEqual(reparsed, argv) {
+		t.Fatalf("requoted command %q reparsed to %#v, want %#v", requoted, reparsed, argv)
+	}
+}
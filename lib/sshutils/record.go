@@ -0,0 +1,79 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sshutils
+
+import (
+	"io"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// streamStdin and streamOutput identify the two data streams a Recorder
+// can be asked to capture: bytes typed by the client (stdin) and bytes
+// written to the terminal, combining stdout and stderr (output).
+const (
+	streamStdin  = "i"
+	streamOutput = "o"
+)
+
+// Recorder receives a copy of a PipeShell session's bytes and PTY
+// resizes as they happen, so they can be persisted for later playback.
+// Implementations must be safe for concurrent use, since stdin and
+// output are recorded from different goroutines.
+type Recorder interface {
+	// Write records data observed on the named stream at time t.
+	Write(streamID string, t time.Time, data []byte) error
+	// Resize records that the PTY was resized to cols x rows at time t.
+	Resize(t time.Time, cols, rows uint32) error
+	// Close flushes and releases any resources held by the recorder.
+	Close() error
+}
+
+// SetRecorder attaches r to this upstream. Once set, PipeShell tees all
+// stdin/output traffic and window-change events to it for later
+// playback. Pass nil to stop recording.
+func (u *Upstream) SetRecorder(r Recorder) {
+	u.Lock()
+	defer u.Unlock()
+	u.recorder = r
+}
+
+func (u *Upstream) getRecorder() Recorder {
+	u.Lock()
+	defer u.Unlock()
+	return u.recorder
+}
+
+// recordingReader tees every byte read from r to u's recorder, tagged as
+// stdin, before handing it back to the caller.
+type recordingReader struct {
+	r io.Reader
+	u *Upstream
+}
+
+func (rr *recordingReader) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	if n > 0 {
+		if recorder := rr.u.getRecorder(); recorder != nil {
+			if rerr := recorder.Write(streamStdin, time.Now(), p[:n]); rerr != nil {
+				logrus.Error(rerr)
+			}
+		}
+	}
+	return n, err
+}
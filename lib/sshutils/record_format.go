@@ -0,0 +1,160 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sshutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gravitational/trace"
+)
+
+// rawRecorder writes a script(1)-style recording: every byte read on
+// stdin and every byte written to the terminal go to their own raw log,
+// and a timing file records how many bytes arrived after how long a
+// delay, so a player can reproduce the original pacing.
+type rawRecorder struct {
+	sync.Mutex
+	stdout, stdin, timing io.Writer
+	last                  time.Time
+}
+
+// NewRawRecorder returns a Recorder that writes stdin.log/stdout.log
+// style raw byte logs plus a script(1)-compatible timing log to the
+// given writers.
+func NewRawRecorder(stdout, stdin, timing io.Writer) Recorder {
+	return &rawRecorder{stdout: stdout, stdin: stdin, timing: timing}
+}
+
+func (r *rawRecorder) Write(streamID string, t time.Time, data []byte) error {
+	r.Lock()
+	defer r.Unlock()
+	if r.last.IsZero() {
+		r.last = t
+	}
+	w := r.stdout
+	if streamID == streamStdin {
+		w = r.stdin
+	}
+	if _, err := w.Write(data); err != nil {
+		return trace.Wrap(err)
+	}
+	_, err := fmt.Fprintf(r.timing, "%.6f %v\n", t.Sub(r.last).Seconds(), len(data))
+	r.last = t
+	return trace.Wrap(err)
+}
+
+func (r *rawRecorder) Resize(t time.Time, cols, rows uint32) error {
+	return nil
+}
+
+func (r *rawRecorder) Close() error {
+	return nil
+}
+
+// asciicastRecorder encodes a session as an asciicast v2 stream, see
+// https://github.com/asciinema/asciinema/blob/develop/doc/asciicast-v2.md
+type asciicastRecorder struct {
+	sync.Mutex
+	enc           *json.Encoder
+	start         time.Time
+	headerWritten bool
+	width, height uint32
+}
+
+// NewAsciicastRecorder returns a Recorder that writes an asciicast v2
+// header line followed by [relSeconds, "o"|"i", payload] event records
+// to w, using width x height as the initial terminal size.
+func NewAsciicastRecorder(w io.Writer, width, height uint32) Recorder {
+	return &asciicastRecorder{enc: json.NewEncoder(w), width: width, height: height}
+}
+
+type asciicastHeader struct {
+	Version   int    `json:"version"`
+	Width     uint32 `json:"width"`
+	Height    uint32 `json:"height"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+func (a *asciicastRecorder) writeHeader(t time.Time) error {
+	a.start = t
+	a.headerWritten = true
+	return a.enc.Encode(asciicastHeader{
+		Version:   2,
+		Width:     a.width,
+		Height:    a.height,
+		Timestamp: t.Unix(),
+	})
+}
+
+func (a *asciicastRecorder) Write(streamID string, t time.Time, data []byte) error {
+	a.Lock()
+	defer a.Unlock()
+	if !a.headerWritten {
+		if err := a.writeHeader(t); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	for len(data) > 0 {
+		chunk, rest := splitUTF8Safe(data)
+		if err := a.enc.Encode([]interface{}{t.Sub(a.start).Seconds(), streamID, string(chunk)}); err != nil {
+			return trace.Wrap(err)
+		}
+		data = rest
+	}
+	return nil
+}
+
+func (a *asciicastRecorder) Resize(t time.Time, cols, rows uint32) error {
+	a.Lock()
+	defer a.Unlock()
+	a.width, a.height = cols, rows
+	if !a.headerWritten {
+		return nil
+	}
+	return trace.Wrap(a.enc.Encode([]interface{}{t.Sub(a.start).Seconds(), "size", cols, rows}))
+}
+
+func (a *asciicastRecorder) Close() error {
+	return nil
+}
+
+// splitUTF8Safe splits data at the last complete rune boundary, so that
+// an incomplete multi-byte UTF-8 sequence at the end of data is returned
+// in rest, apart from the valid prefix in chunk. If data is already
+// valid UTF-8, or no earlier boundary can be found, it is returned as a
+// single chunk. chunk is always non-empty when data is non-empty, so
+// repeatedly calling splitUTF8Safe(rest) is guaranteed to make progress
+// even when data ends in a bare, continuation-less lead byte.
+// asciicastRecorder.Write encodes rest within the same call; nothing is
+// held over to the next Write, so a character split across two Write
+// calls is still recorded as two separate, invalid chunks.
+func splitUTF8Safe(data []byte) (chunk, rest []byte) {
+	if utf8.Valid(data) {
+		return data, nil
+	}
+	for i := len(data) - 1; i > 0 && i > len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) && utf8.Valid(data[:i]) {
+			return data[:i], data[i:]
+		}
+	}
+	return data, nil
+}
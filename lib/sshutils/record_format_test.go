@@ -0,0 +1,98 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sshutils
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSplitUTF8Safe(t *testing.T) {
+	cases := []struct {
+		desc      string
+		data      []byte
+		wantChunk []byte
+		wantRest  []byte
+	}{
+		{
+			desc:      "already valid UTF-8 is returned whole",
+			data:      []byte("hello"),
+			wantChunk: []byte("hello"),
+			wantRest:  nil,
+		},
+		{
+			desc:      "trailing multi-byte char split cleanly off the end",
+			data:      []byte("A\xc2"),
+			wantChunk: []byte("A"),
+			wantRest:  []byte{0xc2},
+		},
+		{
+			desc:      "bare lead byte with no prefix still makes progress",
+			data:      []byte{0xc2},
+			wantChunk: []byte{0xc2},
+			wantRest:  nil,
+		},
+		{
+			desc:      "bare 3-byte lead byte with no prefix still makes progress",
+			data:      []byte{0xe0},
+			wantChunk: []byte{0xe0},
+			wantRest:  nil,
+		},
+		{
+			desc:      "bare 4-byte lead byte with no prefix still makes progress",
+			data:      []byte{0xf0},
+			wantChunk: []byte{0xf0},
+			wantRest:  nil,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			chunk, rest := splitUTF8Safe(tc.data)
+			if !bytes.Equal(chunk, tc.wantChunk) {
+				t.Fatalf("splitUTF8Safe(%v) chunk = %v, want %v", tc.data, chunk, tc.wantChunk)
+			}
+			if !bytes.Equal(rest, tc.wantRest) {
+				t.Fatalf("splitUTF8Safe(%v) rest = %v, want %v", tc.data, rest, tc.wantRest)
+			}
+			if len(tc.data) > 0 && len(chunk) == 0 {
+				t.Fatalf("splitUTF8Safe(%v) returned an empty chunk for non-empty input, no progress would be made", tc.data)
+			}
+		})
+	}
+}
+
+// TestAsciicastRecorderWriteTerminates guards against Write looping
+// forever when data ends in an incomplete multi-byte UTF-8 lead byte,
+// which previously left splitUTF8Safe returning an unchanged rest.
+func TestAsciicastRecorderWriteTerminates(t *testing.T) {
+	done := make(chan error, 1)
+	go func() {
+		var buf bytes.Buffer
+		r := NewAsciicastRecorder(&buf, 80, 24)
+		done <- r.Write(streamOutput, time.Time{}, []byte{0xc2})
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Write returned an error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Write did not terminate: splitUTF8Safe is looping without progress")
+	}
+}
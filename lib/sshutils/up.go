@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/gravitational/trace"
@@ -75,6 +76,12 @@ type Upstream struct {
 	session *ssh.Session
 
 	prefix []byte
+
+	recorder Recorder
+
+	policy CommandPolicy
+
+	mux *MuxedUpstream
 }
 
 func (u *Upstream) SetPrefix(data []byte) {
@@ -110,8 +117,22 @@ func (u *Upstream) Wait() error {
 }
 
 // CommandRW executes a command and returns read writer to communicate
-// with the process using it's stdin and stdout
+// with the process using it's stdin and stdout. The command is tokenized
+// and checked against the configured CommandPolicy, if any, before it is
+// started; the remote side only ever sees the safely requoted form of
+// the tokens that were actually validated, never the raw input, so a
+// policy can't be bypassed by metacharacters the tokenizer didn't split
+// on (e.g. ';', '|', '&').
 func (u *Upstream) CommandRW(command string) (io.ReadWriter, error) {
+	argv, err := ParseCommand(command)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if err := u.checkPolicy(argv); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	command = quoteCommand(argv)
+
 	stdout, err := u.session.StdoutPipe()
 	if err != nil {
 		return nil, trace.Wrap(err, "failed to pipe stdout")
@@ -128,29 +149,48 @@ func (u *Upstream) CommandRW(command string) (io.ReadWriter, error) {
 	return &combo{r: stdout, w: stdin}, nil
 }
 
-// PipeCommand pipes input and output to the read writer, returns
-// result code of the command execution
-func (u *Upstream) PipeCommand(ch io.ReadWriter, command string) (int, error) {
+// PipeCommand pipes input and output to the read writer, returns the
+// exit status and, if the remote process was killed by a signal, the
+// exit-signal details. If sigC is not nil, every ssh.Signal sent on it
+// is forwarded to the remote process as a "signal" request, letting
+// callers relay e.g. SIGINT/SIGTERM from a downstream client. The
+// command is tokenized and checked against the configured CommandPolicy,
+// if any, before it is started; the remote side only ever sees the
+// safely requoted form of the validated tokens, never the raw input.
+func (u *Upstream) PipeCommand(ch io.ReadWriter, command string, sigC <-chan ssh.Signal) (*ExitResult, error) {
+	argv, err := ParseCommand(command)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if err := u.checkPolicy(argv); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	command = quoteCommand(argv)
+
 	stderr, err := u.session.StderrPipe()
 	if err != nil {
-		return -1, trace.Wrap(err, "fail to pipe stderr")
+		return nil, trace.Wrap(err, "fail to pipe stderr")
 	}
 	stdout, err := u.session.StdoutPipe()
 	if err != nil {
-		return -1, trace.Wrap(err, "fail to pipe stdout")
+		return nil, trace.Wrap(err, "fail to pipe stdout")
 	}
 	stdin, err := u.session.StdinPipe()
 	if err != nil {
-		return -1, trace.Wrap(err, "fail to pipe stdin")
+		return nil, trace.Wrap(err, "fail to pipe stdin")
 	}
 	closeC := make(chan error, 4)
 
 	err = u.session.Start(command)
 	if err != nil {
-		return -1, trace.Wrap(err,
+		return nil, trace.Wrap(err,
 			fmt.Sprintf("pipe failed to start command '%v'", command))
 	}
 
+	done := make(chan struct{})
+	defer close(done)
+	u.forwardSignals(sigC, done)
+
 	go func() {
 		_, err := io.Copy(stdin, ch)
 		closeC <- err
@@ -170,42 +210,50 @@ func (u *Upstream) PipeCommand(ch io.ReadWriter, command string) (int, error) {
 		closeC <- u.session.Wait()
 	}()
 
-	err = <-closeC
+	result, err := exitResult(<-closeC)
 	if err != nil {
-		if err, ok := err.(*ssh.ExitError); ok {
-			return err.ExitStatus(), nil
-		}
-		return -1, trace.Wrap(err,
+		return nil, trace.Wrap(err,
 			fmt.Sprintf("failed to collect status of a command '%v'", command))
 	}
-	return 0, nil
+	return result, nil
 }
 
 // PipeShell starts interactive shell and pipes stdin, stdout and stderr
-// to the given read writer
-func (u *Upstream) PipeShell(rw io.ReadWriter, req *PTYReqParams) error {
+// to the given read writer, returning the exit status/signal of the
+// shell once it completes. If resizeC is not nil, every WindowSize sent
+// on it is forwarded to the upstream shell as a "window-change" request,
+// letting a web/tty front-end drive live resizes as the browser terminal
+// is resized, instead of only setting the size once at PTY request time.
+// If sigC is not nil, every ssh.Signal sent on it is forwarded to the
+// shell as a "signal" request.
+func (u *Upstream) PipeShell(rw io.ReadWriter, req *PTYReqParams, resizeC <-chan WindowSize, sigC <-chan ssh.Signal) (*ExitResult, error) {
 	targetStderr, err := u.session.StderrPipe()
 	if err != nil {
-		return trace.Wrap(err, "fail to pipe stderr")
+		return nil, trace.Wrap(err, "fail to pipe stderr")
 	}
 	targetStdout, err := u.session.StdoutPipe()
 	if err != nil {
-		return trace.Wrap(err, "fail to pipe stdout")
+		return nil, trace.Wrap(err, "fail to pipe stdout")
 	}
 	targetStdin, err := u.session.StdinPipe()
 	if err != nil {
-		return trace.Wrap(err, "fail to pipe stdin")
+		return nil, trace.Wrap(err, "fail to pipe stdin")
 	}
 	closeC := make(chan error, 4)
 
 	if err := u.session.Shell(); err != nil {
-		return trace.Wrap(err, "failed to start shell")
+		return nil, trace.Wrap(err, "failed to start shell")
 	}
 
 	if req != nil {
 		u.session.SendRequest(PTYReq, false, ssh.Marshal(*req))
 	}
 
+	done := make(chan struct{})
+	defer close(done)
+	u.watchWindowChanges(resizeC, done)
+	u.forwardSignals(sigC, done)
+
 	// getPrefix protects u.prefix with a mutex
 	getPrefix := func() []byte {
 		u.Lock()
@@ -226,6 +274,11 @@ func (u *Upstream) PipeShell(rw io.ReadWriter, req *PTYReqParams) error {
 			if err != nil {
 				break
 			}
+			if recorder := u.getRecorder(); recorder != nil {
+				if rerr := recorder.Write(streamOutput, time.Now(), buffer[:n]); rerr != nil {
+					logrus.Error(rerr)
+				}
+			}
 			if prefix != nil {
 				pl := len(prefix)
 				if pl+n <= buflen {
@@ -245,7 +298,7 @@ func (u *Upstream) PipeShell(rw io.ReadWriter, req *PTYReqParams) error {
 	}
 
 	go func() {
-		_, err := io.Copy(targetStdin, rw)
+		_, err := io.Copy(targetStdin, &recordingReader{r: rw, u: u})
 		closeC <- err
 	}()
 
@@ -261,7 +314,11 @@ func (u *Upstream) PipeShell(rw io.ReadWriter, req *PTYReqParams) error {
 		closeC <- u.session.Wait()
 	}()
 
-	return <-closeC
+	result, err := exitResult(<-closeC)
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to collect status of shell session")
+	}
+	return result, nil
 }
 
 type combo struct {
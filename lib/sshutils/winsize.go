@@ -0,0 +1,94 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sshutils
+
+import (
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/gravitational/trace"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// WindowChangeReq is the SSH channel request name used to notify the
+// remote side that the terminal window size has changed, see
+// http://tools.ietf.org/html/rfc4254#section-6.7
+const WindowChangeReq = "window-change"
+
+// WindowSize is a terminal window size expressed in character cells
+type WindowSize struct {
+	// Width is the terminal width in columns
+	Width uint32
+	// Height is the terminal height in rows
+	Height uint32
+}
+
+// winChangeReqParams is the wire format of a "window-change" channel
+// request as defined in RFC 4254 section 6.7. The pixel dimensions are
+// left at zero, matching the rest of this package which only tracks
+// character cells.
+type winChangeReqParams struct {
+	W   uint32
+	H   uint32
+	Wpx uint32
+	Hpx uint32
+}
+
+// WindowChange notifies the upstream session that the terminal has been
+// resized to cols x rows, by sending a "window-change" request on the
+// underlying SSH session.
+func (u *Upstream) WindowChange(cols, rows uint32) error {
+	_, err := u.session.SendRequest(WindowChangeReq, false, ssh.Marshal(winChangeReqParams{
+		W: cols,
+		H: rows,
+	}))
+	return trace.Wrap(err)
+}
+
+// watchWindowChanges relays window size updates received on sizeC to the
+// upstream session until sizeC is closed or done is closed, whichever
+// happens first. It is a no-op if sizeC is nil, allowing callers that
+// don't need live resizing to keep using PipeShell exactly as before.
+// done must be closed by the caller once the session it belongs to ends,
+// so that a caller forgetting to close sizeC doesn't leak this goroutine
+// for the life of the process.
+func (u *Upstream) watchWindowChanges(sizeC <-chan WindowSize, done <-chan struct{}) {
+	if sizeC == nil {
+		return
+	}
+	go func() {
+		for {
+			select {
+			case size, ok := <-sizeC:
+				if !ok {
+					return
+				}
+				if err := u.WindowChange(size.Width, size.Height); err != nil {
+					logrus.Error(err)
+				}
+				if recorder := u.getRecorder(); recorder != nil {
+					if err := recorder.Resize(time.Now(), size.Width, size.Height); err != nil {
+						logrus.Error(err)
+					}
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+}